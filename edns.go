@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// defaultEDNSBufsize is used when EDNS0 is needed (DNSSEC, NSID, or ECS was
+// requested) but the user didn't set -edns-bufsize explicitly.
+const defaultEDNSBufsize = 1232
+
+// ednsOptions captures the EDNS0-related flags and attaches the
+// corresponding OPT record to outgoing queries.
+type ednsOptions struct {
+	dnssec  bool
+	bufsize uint16
+	nsid    bool
+	subnet  *dns.EDNS0_SUBNET
+}
+
+// newEDNSOptions validates and packages the EDNS0 flags. subnetCIDR may be
+// empty to disable RFC 7871 Client Subnet.
+func newEDNSOptions(dnssec bool, bufsize int, nsid bool, subnetCIDR string) (*ednsOptions, error) {
+	e := &ednsOptions{dnssec: dnssec, bufsize: uint16(bufsize), nsid: nsid}
+
+	if subnetCIDR != "" {
+		ip, ipnet, err := net.ParseCIDR(subnetCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -subnet %q: %v", subnetCIDR, err)
+		}
+		ones, _ := ipnet.Mask.Size()
+		family := uint16(1)
+		addr := ip.To4()
+		if addr == nil {
+			family = 2
+			addr = ip.To16()
+		}
+		e.subnet = &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        family,
+			SourceNetmask: uint8(ones),
+			Address:       addr,
+		}
+	}
+
+	if e.bufsize == 0 && (dnssec || nsid || e.subnet != nil) {
+		e.bufsize = defaultEDNSBufsize
+	}
+
+	return e, nil
+}
+
+// enabled reports whether any EDNS0 option requires attaching an OPT
+// record to the query.
+func (e *ednsOptions) enabled() bool {
+	return e.bufsize > 0 || e.dnssec || e.nsid || e.subnet != nil
+}
+
+// apply attaches the configured OPT record to m. e may be nil, in which
+// case it is a no-op.
+func (e *ednsOptions) apply(m *dns.Msg) {
+	if e == nil || !e.enabled() {
+		return
+	}
+
+	bufsize := e.bufsize
+	if bufsize == 0 {
+		bufsize = dns.MinMsgSize
+	}
+	m.SetEdns0(bufsize, e.dnssec)
+	if e.dnssec {
+		m.CheckingDisabled = true
+	}
+
+	opt := m.IsEdns0()
+	if e.nsid {
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+	if e.subnet != nil {
+		opt.Option = append(opt.Option, e.subnet)
+	}
+}
+
+// msgFlags renders the set header flags in dig's "qr aa tc rd ra ad cd"
+// style, comma-separated for the flags= field of every probe line.
+func msgFlags(m *dns.Msg) string {
+	var flags []string
+	if m.Response {
+		flags = append(flags, "qr")
+	}
+	if m.Authoritative {
+		flags = append(flags, "aa")
+	}
+	if m.Truncated {
+		flags = append(flags, "tc")
+	}
+	if m.RecursionDesired {
+		flags = append(flags, "rd")
+	}
+	if m.RecursionAvailable {
+		flags = append(flags, "ra")
+	}
+	if m.AuthenticatedData {
+		flags = append(flags, "ad")
+	}
+	if m.CheckingDisabled {
+		flags = append(flags, "cd")
+	}
+	return strings.Join(flags, ",")
+}
+
+// nsidFromResponse returns the NSID string the server attached to resp, if
+// any was requested and returned.
+func nsidFromResponse(resp *dns.Msg) (string, bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return "", false
+	}
+	for _, o := range opt.Option {
+		if n, ok := o.(*dns.EDNS0_NSID); ok {
+			return n.String(), true
+		}
+	}
+	return "", false
+}
@@ -5,22 +5,33 @@ import (
 	"flag"
 	"fmt"
 	"math"
-	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/miekg/dns"
+	"github.com/dlclark/dnsping/stats"
 )
 
-var dnsServer = ""
-var dnsPort = flag.Int("port", 53, "port to connect to the DNS server")
+var dnsPort = flag.Int("port", 0, "port to connect to the DNS server (default depends on -proto)")
 var hostName = flag.String("host", "wikipedia.org", "host name to ask DNS server to resolve")
-var recordType = flag.String("rdatatype", "A", "DNS record type of the query")
+var recordType = flag.String("rdatatype", "A", "DNS record type(s) of the query; a comma-separated list rotates per seq")
 var count = flag.Int("c", 10, "number of times to query")
 var interval = flag.Duration("W", time.Second*1, "wait time between pings")
 var timeout = flag.Duration("t", time.Second*2, "amount of time to wait for a response")
+var protoFlag = flag.String("proto", "udp", "transport to use: udp, tcp, tls, https, quic")
+var insecure = flag.Bool("insecure", false, "skip TLS certificate verification for tls/https/quic")
+var randomize = flag.Bool("randomize", false, "prepend a random label to the hostname on each query to defeat caching")
+var perType = flag.Bool("per-type", false, "break down final statistics by record type")
+var serveAddr = flag.String("serve", "", "if set, probe forever and serve Prometheus metrics at http://<addr>/metrics instead of exiting after -c probes")
+var dnssec = flag.Bool("dnssec", false, "set the DO and CD bits; the AD flag of each response is shown in flags= but not checked")
+var ednsBufsize = flag.Int("edns-bufsize", 0, "attach an EDNS0 OPT record advertising this UDP buffer size")
+var nsid = flag.Bool("nsid", false, "request the server's NSID (RFC 5001) and print it")
+var subnet = flag.String("subnet", "", "attach an EDNS Client Subnet option (RFC 7871) for the given CIDR")
+var verbose = flag.Bool("v", false, "print full answer records for each response")
+var outputFormat = flag.String("output", "text", "output format: text, json, ndjson, csv")
 
 // atomic -- 0 if running, non-zero if exiting
 var stopping int32
@@ -29,18 +40,31 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr,
 			`Usage:
-  %s [options] [server]
+  %s [options] [server[,server...]]
 
-Measure response time to the given DNS server by asking it to resolve a specified host. 
+Measure response time to the given DNS server by asking it to resolve a specified host.
+A comma-separated list of servers probes all of them concurrently on each interval tick
+and prints a side-by-side summary at the end.
 
 OPTIONS
   -h                    show this help
-  -port <int>           port to connect to the DNS server (default 53)
+  -port <int>           port to connect to the DNS server (default depends on -proto)
   -host	<string>        host name to ask DNS server to resolve (default "wikipedia.org")
-  -rdatatype <string>   DNS record type of the query (default "A")
+  -rdatatype <string>   DNS record type(s) of the query, comma-separated to rotate per seq (default "A")
   -c <int>              number of times to query (default 10)
   -W <duration>         wait time between pings (default 1s)
   -t <duration>         amount of time to wait for a server response (default 2s)
+  -proto <string>       transport to use: udp, tcp, tls, https, quic (default "udp")
+  -insecure             skip TLS certificate verification for tls/https/quic
+  -randomize            prepend a random label to the hostname on each query to defeat caching
+  -per-type             break down final statistics by record type
+  -serve <addr>         probe forever and serve Prometheus metrics at http://<addr>/metrics
+  -dnssec               set the DO and CD bits; the AD flag of each response is shown in flags= but not checked
+  -edns-bufsize <int>   attach an EDNS0 OPT record advertising this UDP buffer size
+  -nsid                 request the server's NSID (RFC 5001) and print it
+  -subnet <cidr>        attach an EDNS Client Subnet option (RFC 7871) for the given CIDR
+  -v                    print full answer records for each response
+  -output <string>      output format: text, json, ndjson, csv (default "text")
 `, os.Args[0])
 
 	}
@@ -51,7 +75,36 @@ OPTIONS
 		os.Exit(2)
 	}
 
-	dnsServer = flag.Args()[0]
+	servers := strings.Split(flag.Args()[0], ",")
+
+	transportProto, err := parseProto(*protoFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if *dnsPort == 0 {
+		*dnsPort = defaultPort(transportProto)
+	}
+
+	plan, err := newQueryPlan(*recordType, *randomize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	edns, err := newEDNSOptions(*dnssec, *ednsBufsize, *nsid, *subnet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch *outputFormat {
+	case "text", "json", "ndjson", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid -output %q: must be one of text, json, ndjson, csv\n", *outputFormat)
+		os.Exit(2)
+	}
+	out := newRecorder(*outputFormat)
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
@@ -68,78 +121,84 @@ OPTIONS
 		}
 	}()
 
-	// make sure our DNS server is a legit IP
-	if ip := net.ParseIP(dnsServer); ip == nil {
-		// not an IP, so resolve it as a DNS name
-		ips, err := net.DefaultResolver.LookupIPAddr(ctx, dnsServer)
-		if err != nil || len(ips) == 0 {
-			fmt.Fprintf(os.Stderr, "Error: cannot resolve dns server hostname: %v\n", dnsServer)
+	var collector *stats.Collector
+	if *serveAddr != "" {
+		collector = stats.New()
+		go serveMetrics(ctx, *serveAddr, collector)
+	}
+
+	probers := make([]*prober, 0, len(servers))
+	for _, server := range servers {
+		pr, err := newProber(ctx, server, *dnsPort, transportProto, *timeout, *insecure, collector, edns, *verbose, out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-
-		dnsServer = ips[0].IP.String()
+		probers = append(probers, pr)
+		if *outputFormat == "text" {
+			fmt.Printf("PING DNS: %s:%d, hostname: %s, rdatatype: %s\n", pr.ip, *dnsPort, *hostName, *recordType)
+		}
 	}
 
-	// make sure our type is valid
-	if _, ok := dns.StringToType[*recordType]; !ok {
-		fmt.Fprintf(os.Stderr, "Error: invalid DNS record type %v", *recordType)
-		os.Exit(1)
+	probeCount := *count
+	if *serveAddr != "" {
+		// probe forever; only a Ctrl-C (which cancels ctx) stops us
+		probeCount = 1<<31 - 1
 	}
 
-	fmt.Printf("PING DNS: %s:%d, hostname: %s, rdatatype: %s\n", dnsServer, *dnsPort, *hostName, *recordType)
-
-	var responseTimes []time.Duration
-	var requests int
+	var wg sync.WaitGroup
+	for _, pr := range probers {
+		wg.Add(1)
+		go pr.run(ctx, &wg, *interval, probeCount, *hostName, plan)
+	}
+	wg.Wait()
 
-	resolver := &dns.Client{
-		Timeout: *timeout,
+	if *serveAddr != "" {
+		return
 	}
 
-	m := new(dns.Msg).SetQuestion(dns.Fqdn(*hostName), dns.StringToType[*recordType])
+	summaries := make([]summaryRecord, 0, len(probers))
+	for _, pr := range probers {
+		summaries = append(summaries, pr.buildSummary())
+	}
 
-	for i := 0; i < *count; i++ {
-		if isStopping() {
-			break
-		}
+	if *outputFormat != "text" {
+		out.finish(summaries)
+		return
+	}
 
-		requests++
-		resp, dur, err := resolver.ExchangeContext(ctx, m, fmt.Sprintf("%v:%v", dnsServer, *dnsPort))
-		//fmt.Printf("Response: %#v", resp)
-		if err != nil {
-			if e, ok := err.(*net.OpError); ok {
-				if e.Timeout() {
-					fmt.Printf("Request timeout for seq %v\n", i)
-					continue
-				}
+	if *perType {
+		fmt.Printf("\n--- dnsping per-record-type statistics ---\n")
+		fmt.Printf("%-20s %-6s %6s %8s %8s %8s %8s\n", "server", "type", "recv", "min", "avg", "max", "stddev")
+		for _, pr := range probers {
+			for _, line := range pr.perTypeSummary() {
+				fmt.Println(line)
 			}
-			// all other errors are considered fatal for now
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		invalid := ""
-		if len(resp.Answer) == 0 {
-			// no error, but no answer means invalid hostname response
-			// inform the user -- could impact response time of server
-			invalid = " (invalid hostname)"
 		}
+	}
 
-		responseTimes = append(responseTimes, dur)
-		fmt.Printf("%d bytes from %s: seq=%-3d time=%0.3f ms%v\n", resp.Len(), dnsServer, i, inMilli(dur), invalid)
-		// sleep as needed
-		if sleepTime := *interval - dur; sleepTime > 0 {
-			time.Sleep(sleepTime)
-		}
+	fmt.Printf("\n--- dnsping rcode histogram ---\n")
+	for _, pr := range probers {
+		fmt.Println(pr.rcodeHistogram())
 	}
 
-	lostPercent := 0.0
-	if requests > 0 {
-		lostPercent = float64(100*(requests-len(responseTimes))) / float64(requests)
+	if len(probers) > 1 {
+		fmt.Printf("\n--- dnsping summary ---\n")
+		fmt.Printf("%-20s %6s %6s %7s %8s %8s %8s %8s\n", "server", "xmit", "recv", "loss", "min", "avg", "max", "stddev")
+		for _, pr := range probers {
+			fmt.Println(pr.summary())
+		}
+		for _, s := range summaries {
+			fmt.Printf("%-20s jitter=%.3f p50/p90/p95/p99 = %.3f/%.3f/%.3f/%.3f ms\n", s.Server, s.JitterMs, s.P50Ms, s.P90Ms, s.P95Ms, s.P99Ms)
+		}
+		return
 	}
 
-	fmt.Printf("\n--- %s dnsping statistics ---\n", dnsServer)
-	fmt.Printf("%d requests transmitted, %d responses received, %.1f%% lost\n", requests, len(responseTimes), lostPercent)
-	fmt.Printf("round-trip min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f ms\n", min(responseTimes), avg(responseTimes), max(responseTimes), stddev(responseTimes))
+	pr, s := probers[0], summaries[0]
+	fmt.Printf("\n--- %s dnsping statistics ---\n", pr.name)
+	fmt.Printf("%d requests transmitted, %d responses received, %.1f%% lost\n", s.Requests, s.Received, s.LossPct)
+	fmt.Printf("round-trip min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f ms\n", s.MinMs, s.AvgMs, s.MaxMs, s.StddevMs)
+	fmt.Printf("jitter = %.3f ms, percentiles p50/p90/p95/p99 = %.3f/%.3f/%.3f/%.3f ms\n", s.JitterMs, s.P50Ms, s.P90Ms, s.P95Ms, s.P99Ms)
 }
 
 func min(times []time.Duration) float64 {
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// probeRecord is the structured form of one probe result, shared by the
+// json, ndjson, and csv output formats.
+type probeRecord struct {
+	Seq     int      `json:"seq"`
+	Server  string   `json:"server"`
+	Qname   string   `json:"qname"`
+	Qtype   string   `json:"qtype"`
+	Proto   string   `json:"proto"`
+	RTTMs   float64  `json:"rtt_ms"`
+	Rcode   string   `json:"rcode,omitempty"`
+	Flags   string   `json:"flags,omitempty"`
+	Bytes   int      `json:"bytes,omitempty"`
+	Invalid bool     `json:"-"`
+	NSID    string   `json:"nsid,omitempty"`
+	Answers []string `json:"answers,omitempty"`
+	Error   string   `json:"error,omitempty"`
+
+	// errKind is only used to pick the text-mode message; it isn't part
+	// of the structured schemas.
+	errKind errKind
+}
+
+// summaryRecord is the structured form of one server's final statistics.
+type summaryRecord struct {
+	Server   string  `json:"server"`
+	Requests int     `json:"requests"`
+	Received int     `json:"received"`
+	LossPct  float64 `json:"loss_pct"`
+	MinMs    float64 `json:"min_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	StddevMs float64 `json:"stddev_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+}
+
+var csvFields = []string{"seq", "server", "qname", "qtype", "proto", "rtt_ms", "rcode", "bytes", "error"}
+
+// recorder renders probe results and final summaries in the configured
+// -output format. All output goes through a single mutex so the
+// one-goroutine-per-server probers don't interleave partial lines or CSV
+// rows.
+type recorder struct {
+	format string // "text", "json", "ndjson", "csv"
+
+	mu         sync.Mutex
+	csvWriter  *csv.Writer
+	csvStarted bool
+	jsonResult struct {
+		Results []probeRecord   `json:"results"`
+		Summary []summaryRecord `json:"summary"`
+	}
+}
+
+func newRecorder(format string) *recorder {
+	return &recorder{format: format, csvWriter: csv.NewWriter(os.Stdout)}
+}
+
+// recordProbe renders a single probe result according to the output
+// format.
+func (r *recorder) recordProbe(rec probeRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.format {
+	case "json":
+		r.jsonResult.Results = append(r.jsonResult.Results, rec)
+	case "ndjson":
+		b, _ := json.Marshal(rec)
+		fmt.Println(string(b))
+	case "csv":
+		r.writeCSVRowLocked(rec)
+	default: // "text"
+		r.writeTextLocked(rec)
+	}
+}
+
+func (r *recorder) writeCSVRowLocked(rec probeRecord) {
+	if !r.csvStarted {
+		r.csvWriter.Write(csvFields)
+		r.csvStarted = true
+	}
+	r.csvWriter.Write([]string{
+		strconv.Itoa(rec.Seq),
+		rec.Server,
+		rec.Qname,
+		rec.Qtype,
+		rec.Proto,
+		strconv.FormatFloat(rec.RTTMs, 'f', 3, 64),
+		rec.Rcode,
+		strconv.Itoa(rec.Bytes),
+		rec.Error,
+	})
+	r.csvWriter.Flush()
+}
+
+func (r *recorder) writeTextLocked(rec probeRecord) {
+	if rec.Error != "" {
+		switch rec.errKind {
+		case errKindTimeout:
+			fmt.Printf("%s: Request timeout for seq %v\n", rec.Server, rec.Seq)
+		case errKindHandshake:
+			fmt.Printf("%s: Handshake failed for seq %v: %v\n", rec.Server, rec.Seq, rec.Error)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: Error: %v\n", rec.Server, rec.Error)
+		}
+		return
+	}
+
+	invalid := ""
+	if rec.Invalid {
+		invalid = " (invalid hostname)"
+	}
+	fmt.Printf("%d bytes from %s: seq=%-3d qtype=%s rcode=%s flags=%s time=%0.3f ms%v\n",
+		rec.Bytes, rec.Server, rec.Seq, rec.Qtype, rec.Rcode, rec.Flags, rec.RTTMs, invalid)
+
+	if rec.NSID != "" {
+		fmt.Printf("  nsid: %s\n", rec.NSID)
+	}
+	for _, a := range rec.Answers {
+		fmt.Printf("  %s\n", a)
+	}
+}
+
+// recordHandshake prints the one-time session handshake duration. It is
+// only surfaced in text mode; the structured schemas don't have a field
+// for it.
+func (r *recorder) recordHandshake(server string, ms float64) {
+	if r.format != "text" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Printf("handshake with %s: time=%0.3f ms\n", server, ms)
+}
+
+// finish renders the final summaries for the json and ndjson formats; csv
+// output has no summary rows. Text-mode summaries are printed directly by
+// main, using the same summaries slice.
+func (r *recorder) finish(summaries []summaryRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.format {
+	case "json":
+		r.jsonResult.Summary = summaries
+		b, _ := json.MarshalIndent(r.jsonResult, "", "  ")
+		fmt.Println(string(b))
+	case "ndjson":
+		for _, s := range summaries {
+			b, _ := json.Marshal(struct {
+				Summary summaryRecord `json:"summary"`
+			}{s})
+			fmt.Println(string(b))
+		}
+	}
+}
+
+// jitterMs computes the RFC 3550 interarrival jitter (mean of absolute
+// successive differences) of times, in milliseconds.
+func jitterMs(times []time.Duration) float64 {
+	if len(times) < 2 {
+		return 0
+	}
+	sum := 0.0
+	for i := 1; i < len(times); i++ {
+		diff := inMilli(times[i]) - inMilli(times[i-1])
+		if diff < 0 {
+			diff = -diff
+		}
+		sum += diff
+	}
+	return sum / float64(len(times)-1)
+}
+
+// percentileMs returns the p-th percentile (0-100) of times, in
+// milliseconds, using the nearest-rank method on a sorted copy.
+func percentileMs(times []time.Duration, p float64) float64 {
+	if len(times) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return inMilli(sorted[rank])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func ms(vals ...int) []time.Duration {
+	times := make([]time.Duration, len(vals))
+	for i, v := range vals {
+		times[i] = time.Duration(v) * time.Millisecond
+	}
+	return times
+}
+
+func TestJitterMs(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []time.Duration
+		want float64
+	}{
+		{"empty", ms(), 0},
+		{"single", ms(10), 0},
+		{"constant", ms(10, 10, 10), 0},
+		{"alternating", ms(10, 20, 10, 20), 10},
+		{"decreasing", ms(30, 20, 10), 10},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := jitterMs(c.in); math.Abs(got-c.want) > 1e-9 {
+				t.Errorf("jitterMs(%v) = %v, want %v", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestPercentileMs(t *testing.T) {
+	times := ms(10, 20, 30, 40, 50)
+	cases := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 10},
+		{50, 30},
+		{90, 50},
+		{100, 50},
+	}
+	for _, c := range cases {
+		if got := percentileMs(times, c.p); got != c.want {
+			t.Errorf("percentileMs(%v, %v) = %v, want %v", times, c.p, got, c.want)
+		}
+	}
+}
+
+func TestPercentileMsEmpty(t *testing.T) {
+	if got := percentileMs(nil, 50); got != 0 {
+		t.Errorf("percentileMs(nil, 50) = %v, want 0", got)
+	}
+}
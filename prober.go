@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/dlclark/dnsping/stats"
+)
+
+// sample is one successful probe's timing, tagged with the record type
+// queried so results can be broken down with -per-type.
+type sample struct {
+	qtype uint16
+	dur   time.Duration
+}
+
+// prober probes a single DNS server on every tick of its own ticker,
+// printing one line per seq and accumulating its own response times so a
+// multi-server run can print a side-by-side summary at the end.
+type prober struct {
+	name      string // as given on the command line (hostname or IP)
+	ip        string // resolved IP
+	port      int
+	protoName string
+	resolver  exchanger
+	collector *stats.Collector // nil unless -serve is set
+	edns      *ednsOptions
+	verbose   bool
+	out       *recorder
+
+	mu            sync.Mutex
+	responseTimes []time.Duration
+	samples       []sample
+	rcodes        map[int]int
+	requests      int
+}
+
+// newProber resolves server (a hostname or IP) and builds the exchanger for
+// it. It does not start probing. collector may be nil; when set, every
+// probe also feeds the Prometheus metrics exposed by -serve.
+func newProber(ctx context.Context, server string, port int, p proto, timeout time.Duration, insecure bool, collector *stats.Collector, edns *ednsOptions, verbose bool, out *recorder) (*prober, error) {
+	ip := server
+	if parsed := net.ParseIP(server); parsed == nil {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, server)
+		if err != nil || len(ips) == 0 {
+			return nil, fmt.Errorf("cannot resolve dns server hostname: %v", server)
+		}
+		ip = ips[0].IP.String()
+	}
+
+	return &prober{
+		name:      server,
+		ip:        ip,
+		port:      port,
+		protoName: string(p),
+		resolver:  newExchanger(p, fmt.Sprintf("%v:%v", ip, port), timeout, insecure),
+		collector: collector,
+		edns:      edns,
+		verbose:   verbose,
+		out:       out,
+		rcodes:    make(map[int]int),
+	}, nil
+}
+
+// run probes the server once per interval, up to count times, printing each
+// result to stdout. It returns once count probes have been attempted or ctx
+// is canceled. wg.Done is called on return so callers can run one prober per
+// goroutine and wait on a shared WaitGroup.
+//
+// Each prober owns its own ticker rather than sharing one across goroutines:
+// a single time.Ticker's channel delivers each tick to exactly one receiver,
+// so N probers reading the same ticker would each only get every Nth tick.
+func (pr *prober) run(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, count int, host string, plan *queryPlan) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if isStopping() {
+			return
+		}
+
+		m := plan.msgForSeq(i, host)
+		pr.edns.apply(m)
+
+		pr.mu.Lock()
+		pr.requests++
+		pr.mu.Unlock()
+
+		qname := strings.TrimSuffix(m.Question[0].Name, ".")
+		qtype := dns.TypeToString[m.Question[0].Qtype]
+
+		resp, dur, err := pr.resolver.Exchange(ctx, m)
+		if hd, ok := pr.resolver.takeHandshake(); ok {
+			pr.out.recordHandshake(pr.ip, inMilli(hd))
+		}
+		if err != nil {
+			kind := classifyErr(err)
+			pr.out.recordProbe(probeRecord{
+				Seq: i, Server: pr.name, Qname: qname, Qtype: qtype, Proto: pr.protoName,
+				Error: err.Error(), errKind: kind,
+			})
+			if pr.collector != nil {
+				// dnsping_timeouts_total and dnsping_errors_total{kind="handshake"}
+				// depend on classifyErr correctly unwrapping the *url.Error/quic-go
+				// errors that the https and quic transports return; a
+				// misclassification here buckets everything as "other".
+				if kind == errKindTimeout {
+					pr.collector.IncTimeout()
+				} else {
+					pr.collector.IncError(errKindName(kind))
+				}
+			}
+			continue
+		}
+
+		pr.mu.Lock()
+		pr.responseTimes = append(pr.responseTimes, dur)
+		pr.samples = append(pr.samples, sample{qtype: m.Question[0].Qtype, dur: dur})
+		pr.rcodes[resp.Rcode]++
+		pr.mu.Unlock()
+
+		if pr.collector != nil {
+			pr.collector.ObserveRTT(stats.Labels{
+				Server: pr.name,
+				Qtype:  qtype,
+				Proto:  pr.protoName,
+				Rcode:  dns.RcodeToString[resp.Rcode],
+			}, dur.Seconds())
+		}
+
+		rec := probeRecord{
+			Seq: i, Server: pr.name, Qname: qname, Qtype: qtype, Proto: pr.protoName,
+			RTTMs: inMilli(dur), Rcode: dns.RcodeToString[resp.Rcode], Flags: msgFlags(resp), Bytes: resp.Len(),
+			Invalid: resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0,
+		}
+		if nsid, ok := nsidFromResponse(resp); ok {
+			rec.NSID = nsid
+		}
+		if pr.verbose {
+			for _, rr := range resp.Answer {
+				rec.Answers = append(rec.Answers, rr.String())
+			}
+		}
+		pr.out.recordProbe(rec)
+	}
+}
+
+// errKindName maps an errKind to the label value used for
+// dnsping_errors_total{kind=...}.
+func errKindName(k errKind) string {
+	switch k {
+	case errKindHandshake:
+		return "handshake"
+	default:
+		return "other"
+	}
+}
+
+// perTypeSummary renders one statistics line per distinct record type
+// queried during the run, for -per-type.
+func (pr *prober) perTypeSummary() []string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	var order []uint16
+	byType := map[uint16][]time.Duration{}
+	for _, s := range pr.samples {
+		if _, ok := byType[s.qtype]; !ok {
+			order = append(order, s.qtype)
+		}
+		byType[s.qtype] = append(byType[s.qtype], s.dur)
+	}
+
+	lines := make([]string, 0, len(order))
+	for _, qtype := range order {
+		times := byType[qtype]
+		lines = append(lines, fmt.Sprintf("%-20s %-6s %6d %8.3f %8.3f %8.3f %8.3f",
+			pr.name, dns.TypeToString[qtype], len(times), min(times), avg(times), max(times), stddev(times)))
+	}
+	return lines
+}
+
+// summary renders this prober's final statistics line.
+func (pr *prober) summary() string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	lostPercent := 0.0
+	if pr.requests > 0 {
+		lostPercent = float64(100*(pr.requests-len(pr.responseTimes))) / float64(pr.requests)
+	}
+
+	return fmt.Sprintf("%-20s %6d %6d %6.1f%% %8.3f %8.3f %8.3f %8.3f",
+		pr.name, pr.requests, len(pr.responseTimes), lostPercent,
+		min(pr.responseTimes), avg(pr.responseTimes), max(pr.responseTimes), stddev(pr.responseTimes))
+}
+
+// buildSummary computes this prober's final statistics, including jitter
+// and percentiles, for the structured output formats and for the text
+// summary lines.
+func (pr *prober) buildSummary() summaryRecord {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	lossPct := 0.0
+	if pr.requests > 0 {
+		lossPct = float64(100*(pr.requests-len(pr.responseTimes))) / float64(pr.requests)
+	}
+
+	return summaryRecord{
+		Server:   pr.name,
+		Requests: pr.requests,
+		Received: len(pr.responseTimes),
+		LossPct:  lossPct,
+		MinMs:    min(pr.responseTimes),
+		AvgMs:    avg(pr.responseTimes),
+		MaxMs:    max(pr.responseTimes),
+		StddevMs: stddev(pr.responseTimes),
+		JitterMs: jitterMs(pr.responseTimes),
+		P50Ms:    percentileMs(pr.responseTimes, 50),
+		P90Ms:    percentileMs(pr.responseTimes, 90),
+		P95Ms:    percentileMs(pr.responseTimes, 95),
+		P99Ms:    percentileMs(pr.responseTimes, 99),
+	}
+}
+
+// rcodeHistogram renders a single line listing the count of each rcode seen
+// during the run, so transient SERVFAILs and the like are visible in the
+// final statistics.
+func (pr *prober) rcodeHistogram() string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	parts := make([]string, 0, len(pr.rcodes))
+	for rcode, n := range pr.rcodes {
+		parts = append(parts, fmt.Sprintf("%s=%d", dns.RcodeToString[rcode], n))
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%-20s %s", pr.name, strings.Join(parts, " "))
+}
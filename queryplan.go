@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// queryPlan decides, for a given probe sequence number, which DNS record
+// type to query and what qname to use. It lets -rdatatype rotate through a
+// comma-separated list of types per seq, and -randomize prepend a random
+// label to the qname so every probe bypasses the resolver's cache.
+type queryPlan struct {
+	types     []uint16
+	randomize bool
+}
+
+// newQueryPlan parses a comma-separated list of record type names (as
+// accepted by -rdatatype) into a queryPlan.
+func newQueryPlan(rdatatypes string, randomize bool) (*queryPlan, error) {
+	var types []uint16
+	for _, s := range strings.Split(rdatatypes, ",") {
+		t, ok := dns.StringToType[s]
+		if !ok {
+			return nil, fmt.Errorf("invalid DNS record type %q", s)
+		}
+		types = append(types, t)
+	}
+	return &queryPlan{types: types, randomize: randomize}, nil
+}
+
+// typeForSeq returns the record type to query for the given probe
+// sequence, rotating through the configured types.
+func (qp *queryPlan) typeForSeq(seq int) uint16 {
+	return qp.types[seq%len(qp.types)]
+}
+
+// nameForSeq returns the qname to query, prepending a random label when
+// -randomize is set.
+func (qp *queryPlan) nameForSeq(host string) string {
+	if !qp.randomize {
+		return host
+	}
+	return randomLabel() + "." + host
+}
+
+// msgForSeq builds the query message for the given probe sequence.
+func (qp *queryPlan) msgForSeq(seq int, host string) *dns.Msg {
+	qtype := qp.typeForSeq(seq)
+	qname := qp.nameForSeq(host)
+	return new(dns.Msg).SetQuestion(dns.Fqdn(qname), qtype)
+}
+
+// randomLabel returns an 8-character hex label suitable for defeating a
+// resolver's cache. It falls back to a constant label if the system RNG is
+// unavailable, which only weakens cache-busting, not correctness.
+func randomLabel() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "dnsping"
+	}
+	return hex.EncodeToString(b)
+}
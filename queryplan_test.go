@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewQueryPlanInvalidType(t *testing.T) {
+	if _, err := newQueryPlan("BOGUS", false); err == nil {
+		t.Fatal("expected error for invalid record type, got nil")
+	}
+}
+
+func TestTypeForSeqRotates(t *testing.T) {
+	qp, err := newQueryPlan("A,AAAA,MX", false)
+	if err != nil {
+		t.Fatalf("newQueryPlan: %v", err)
+	}
+	want := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeMX, dns.TypeA, dns.TypeAAAA}
+	for seq, w := range want {
+		if got := qp.typeForSeq(seq); got != w {
+			t.Errorf("typeForSeq(%d) = %v, want %v", seq, got, w)
+		}
+	}
+}
+
+func TestNameForSeqRandomize(t *testing.T) {
+	qp, err := newQueryPlan("A", false)
+	if err != nil {
+		t.Fatalf("newQueryPlan: %v", err)
+	}
+	if got := qp.nameForSeq("example.com"); got != "example.com" {
+		t.Errorf("nameForSeq without -randomize = %q, want %q", got, "example.com")
+	}
+
+	qp, err = newQueryPlan("A", true)
+	if err != nil {
+		t.Fatalf("newQueryPlan: %v", err)
+	}
+	got := qp.nameForSeq("example.com")
+	if !strings.HasSuffix(got, ".example.com") {
+		t.Errorf("nameForSeq with -randomize = %q, want suffix %q", got, ".example.com")
+	}
+	if got == "example.com" || got == ".example.com" {
+		t.Errorf("nameForSeq with -randomize did not prepend a label: %q", got)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/dlclark/dnsping/stats"
+)
+
+// serveMetrics starts an HTTP server exposing collector at addr's /metrics
+// path in Prometheus text format, for -serve. It runs until ctx is
+// canceled.
+func serveMetrics(ctx context.Context, addr string, collector *stats.Collector) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := collector.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	fmt.Printf("serving Prometheus metrics on %s/metrics\n", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Error: metrics server: %v\n", err)
+		os.Exit(1)
+	}
+}
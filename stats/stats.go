@@ -0,0 +1,202 @@
+// Package stats accumulates dnsping probe results into Prometheus/OpenMetrics
+// counters and histograms so a long-running `dnsping -serve` process can be
+// scraped without serializing every probe behind a single mutex.
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// rttBucketsSeconds are the histogram bucket boundaries for dnsping_rtt_seconds,
+// chosen to cover typical DNS RTTs from sub-millisecond cache hits up to a
+// slow recursive lookup.
+var rttBucketsSeconds = []float64{
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Labels identifies one series within the exporter, matching the label set
+// described for dnsping_rtt_seconds: server, qtype, proto, rcode.
+type Labels struct {
+	Server string
+	Qtype  string
+	Proto  string
+	Rcode  string
+}
+
+// histogram is a fixed-bucket latency histogram with lock-free observation:
+// every bucket count, the overall count, and the sum are plain atomic
+// counters, so Observe never blocks a concurrent Observe.
+type histogram struct {
+	buckets  []uint64 // cumulative per-bucket counts (le >= bucket), index matches rttBucketsSeconds
+	count    uint64
+	sumNanos uint64 // sum of observed durations, in nanoseconds
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make([]uint64, len(rttBucketsSeconds))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, le := range rttBucketsSeconds {
+		if seconds <= le {
+			atomic.AddUint64(&h.buckets[i], 1)
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sumNanos, uint64(seconds*1e9))
+}
+
+// Collector accumulates probe results across the lifetime of a -serve run.
+type Collector struct {
+	mu         sync.RWMutex // write-locked only to create new label-set entries
+	histograms map[Labels]*histogram
+
+	queriesTotal  uint64
+	timeoutsTotal uint64
+
+	errMu       sync.RWMutex // write-locked only to create new error-kind entries
+	errorsTotal map[string]*uint64
+}
+
+// New returns an empty Collector.
+func New() *Collector {
+	return &Collector{
+		histograms:  make(map[Labels]*histogram),
+		errorsTotal: make(map[string]*uint64),
+	}
+}
+
+// ObserveRTT records one successful probe's RTT under the given labels and
+// increments dnsping_queries_total.
+func (c *Collector) ObserveRTT(l Labels, seconds float64) {
+	atomic.AddUint64(&c.queriesTotal, 1)
+	c.histogramFor(l).observe(seconds)
+}
+
+// IncTimeout increments dnsping_timeouts_total and dnsping_queries_total.
+func (c *Collector) IncTimeout() {
+	atomic.AddUint64(&c.queriesTotal, 1)
+	atomic.AddUint64(&c.timeoutsTotal, 1)
+}
+
+// IncError increments dnsping_errors_total{kind=...} and dnsping_queries_total.
+func (c *Collector) IncError(kind string) {
+	atomic.AddUint64(&c.queriesTotal, 1)
+	atomic.AddUint64(c.errCounterFor(kind), 1)
+}
+
+func (c *Collector) histogramFor(l Labels) *histogram {
+	c.mu.RLock()
+	h, ok := c.histograms[l]
+	c.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok = c.histograms[l]
+	if !ok {
+		h = newHistogram()
+		c.histograms[l] = h
+	}
+	return h
+}
+
+func (c *Collector) errCounterFor(kind string) *uint64 {
+	c.errMu.RLock()
+	p, ok := c.errorsTotal[kind]
+	c.errMu.RUnlock()
+	if ok {
+		return p
+	}
+
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	p, ok = c.errorsTotal[kind]
+	if !ok {
+		var v uint64
+		p = &v
+		c.errorsTotal[kind] = p
+	}
+	return p
+}
+
+// WritePrometheus renders the current state of the Collector in Prometheus
+// text exposition format.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP dnsping_queries_total Total DNS queries sent.\n# TYPE dnsping_queries_total counter\ndnsping_queries_total %d\n", atomic.LoadUint64(&c.queriesTotal)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# HELP dnsping_timeouts_total Total DNS queries that timed out.\n# TYPE dnsping_timeouts_total counter\ndnsping_timeouts_total %d\n", atomic.LoadUint64(&c.timeoutsTotal)); err != nil {
+		return err
+	}
+
+	if err := c.writeErrors(w); err != nil {
+		return err
+	}
+	return c.writeRTT(w)
+}
+
+func (c *Collector) writeErrors(w io.Writer) error {
+	c.errMu.Lock()
+	kinds := make([]string, 0, len(c.errorsTotal))
+	for k := range c.errorsTotal {
+		kinds = append(kinds, k)
+	}
+	c.errMu.Unlock()
+	sort.Strings(kinds)
+
+	if _, err := fmt.Fprintf(w, "# HELP dnsping_errors_total Total DNS queries that failed, by error kind.\n# TYPE dnsping_errors_total counter\n"); err != nil {
+		return err
+	}
+	for _, k := range kinds {
+		if _, err := fmt.Fprintf(w, "dnsping_errors_total{kind=%q} %d\n", k, atomic.LoadUint64(c.errCounterFor(k))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Collector) writeRTT(w io.Writer) error {
+	c.mu.Lock()
+	keys := make([]Labels, 0, len(c.histograms))
+	for l := range c.histograms {
+		keys = append(keys, l)
+	}
+	c.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+
+	if _, err := fmt.Fprintf(w, "# HELP dnsping_rtt_seconds DNS query round-trip time in seconds.\n# TYPE dnsping_rtt_seconds histogram\n"); err != nil {
+		return err
+	}
+	for _, l := range keys {
+		h := c.histogramFor(l)
+		labels := fmt.Sprintf("server=%q,qtype=%q,proto=%q,rcode=%q", l.Server, l.Qtype, l.Proto, l.Rcode)
+		for i, le := range rttBucketsSeconds {
+			if _, err := fmt.Fprintf(w, "dnsping_rtt_seconds_bucket{%s,le=%q} %d\n", labels, formatBound(le), atomic.LoadUint64(&h.buckets[i])); err != nil {
+				return err
+			}
+		}
+		count := atomic.LoadUint64(&h.count)
+		if _, err := fmt.Fprintf(w, "dnsping_rtt_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, count); err != nil {
+			return err
+		}
+		sum := float64(atomic.LoadUint64(&h.sumNanos)) / 1e9
+		if _, err := fmt.Fprintf(w, "dnsping_rtt_seconds_sum{%s} %g\n", labels, sum); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "dnsping_rtt_seconds_count{%s} %d\n", labels, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatBound(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistogramObserveCumulative(t *testing.T) {
+	h := newHistogram()
+	h.observe(0.005) // falls in the 0.005, 0.01, ... buckets
+	h.observe(0.2)   // falls in the 0.25, 0.5, ... buckets
+	h.observe(20)    // exceeds every finite bucket
+
+	for i, le := range rttBucketsSeconds {
+		got := h.buckets[i]
+		var want uint64
+		if 0.005 <= le {
+			want++
+		}
+		if 0.2 <= le {
+			want++
+		}
+		if 20 <= le {
+			want++
+		}
+		if got != want {
+			t.Errorf("bucket le=%g = %d, want %d", le, got, want)
+		}
+	}
+	if h.count != 3 {
+		t.Errorf("count = %d, want 3", h.count)
+	}
+}
+
+func TestCollectorWritePrometheus(t *testing.T) {
+	c := New()
+	c.ObserveRTT(Labels{Server: "1.1.1.1", Qtype: "A", Proto: "udp", Rcode: "NOERROR"}, 0.01)
+	c.IncTimeout()
+	c.IncError("handshake")
+
+	var buf strings.Builder
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"dnsping_queries_total 3\n",
+		"dnsping_timeouts_total 1\n",
+		`dnsping_errors_total{kind="handshake"} 1`,
+		`dnsping_rtt_seconds_count{server="1.1.1.1",qtype="A",proto="udp",rcode="NOERROR"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q; got:\n%s", want, out)
+		}
+	}
+}
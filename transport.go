@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// proto identifies the transport used to carry a DNS query.
+type proto string
+
+const (
+	protoUDP   proto = "udp"
+	protoTCP   proto = "tcp"
+	protoTLS   proto = "tls"
+	protoHTTPS proto = "https"
+	protoQUIC  proto = "quic"
+)
+
+// defaultPort returns the well-known port for p, used when the user hasn't
+// overridden -port.
+func defaultPort(p proto) int {
+	switch p {
+	case protoTLS:
+		return 853
+	case protoHTTPS:
+		return 443
+	case protoQUIC:
+		return 853
+	default:
+		return 53
+	}
+}
+
+func parseProto(s string) (proto, error) {
+	switch proto(s) {
+	case protoUDP, protoTCP, protoTLS, protoHTTPS, protoQUIC:
+		return proto(s), nil
+	default:
+		return "", fmt.Errorf("invalid -proto %q: must be one of udp, tcp, tls, https, quic", s)
+	}
+}
+
+// errKind categorizes a failed exchange so callers can report timeouts,
+// handshake failures, and other errors separately instead of lumping them
+// together.
+type errKind int
+
+const (
+	errKindNone errKind = iota
+	errKindTimeout
+	errKindHandshake
+	errKindOther
+)
+
+// handshakeErr wraps an error encountered while establishing a TLS, HTTPS,
+// or QUIC session, so classifyErr can tell it apart from a query timeout.
+type handshakeErr struct {
+	err error
+}
+
+func (h *handshakeErr) Error() string { return "handshake: " + h.err.Error() }
+func (h *handshakeErr) Unwrap() error { return h.err }
+
+func classifyErr(err error) errKind {
+	if err == nil {
+		return errKindNone
+	}
+
+	// Use errors.As/errors.Is instead of direct type assertions or ==:
+	// the http.Client (DoH) and quic-go (DoQ) transports both wrap these
+	// causes rather than returning them directly (e.g. http.Client.Do
+	// always returns a *url.Error wrapping the dial/handshake error).
+	var he *handshakeErr
+	if errors.As(err, &he) {
+		return errKindHandshake
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errKindTimeout
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return errKindTimeout
+	}
+	return errKindOther
+}
+
+// exchanger sends a single DNS query and returns the response along with
+// the wall-clock RTT of the request/response itself (excluding any one-time
+// session handshake, which is reported separately via takeHandshake).
+type exchanger interface {
+	Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, error)
+
+	// takeHandshake returns the duration of the most recent session setup
+	// (dial plus TLS/QUIC handshake) and whether one occurred since the last
+	// call. Only the tls, https, and quic transports record one; udp and tcp
+	// never do. It is consumed so it is only reported once per session, on
+	// the probe that paid for it.
+	takeHandshake() (time.Duration, bool)
+}
+
+// newExchanger builds the exchanger for the given proto. addr is the
+// "host:port" of the DNS server.
+func newExchanger(p proto, addr string, timeout time.Duration, insecure bool) exchanger {
+	switch p {
+	case protoHTTPS:
+		return newDoHExchanger(addr, timeout, insecure)
+	case protoQUIC:
+		return newDoQExchanger(addr, timeout, insecure)
+	default:
+		return newClassicExchanger(p, addr, timeout, insecure)
+	}
+}
+
+// classicExchanger covers udp, tcp, and tls (DoT) via the miekg/dns client,
+// which already knows how to speak all three.
+type classicExchanger struct {
+	client       *dns.Client
+	addr         string
+	conn         *dns.Conn
+	handshake    time.Duration
+	hasHandshake bool
+}
+
+func newClassicExchanger(p proto, addr string, timeout time.Duration, insecure bool) *classicExchanger {
+	c := &dns.Client{Timeout: timeout}
+	switch p {
+	case protoTCP:
+		c.Net = "tcp"
+	case protoTLS:
+		c.Net = "tcp-tls"
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	return &classicExchanger{client: c, addr: addr}
+}
+
+func (c *classicExchanger) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	if c.client.Net != "tcp-tls" {
+		return c.client.ExchangeContext(ctx, m, c.addr)
+	}
+
+	// Keep the TLS session open across calls so only the first probe pays
+	// for the handshake; otherwise dns.Client.ExchangeContext would dial
+	// (and tear down) a fresh connection on every single query.
+	if c.conn == nil {
+		start := time.Now()
+		conn, err := c.client.Dial(c.addr)
+		if err != nil {
+			return nil, 0, &handshakeErr{err}
+		}
+		c.conn = conn
+		c.handshake = time.Since(start)
+		c.hasHandshake = true
+	}
+	return c.client.ExchangeWithConnContext(ctx, m, c.conn)
+}
+
+func (c *classicExchanger) takeHandshake() (time.Duration, bool) {
+	if !c.hasHandshake {
+		return 0, false
+	}
+	d := c.handshake
+	c.handshake = 0
+	c.hasHandshake = false
+	return d, true
+}
+
+// dohExchanger implements DoH (RFC 8484) by POSTing the packed wire-format
+// query to https://addr/dns-query and unpacking the wire-format body of the
+// response.
+type dohExchanger struct {
+	httpClient   *http.Client
+	url          string
+	tlsConfig    *tls.Config
+	handshake    time.Duration
+	hasHandshake bool
+}
+
+func newDoHExchanger(addr string, timeout time.Duration, insecure bool) *dohExchanger {
+	d := &dohExchanger{
+		url:       fmt.Sprintf("https://%s/dns-query", addr),
+		tlsConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}
+	tr := &http.Transport{
+		TLSClientConfig: d.tlsConfig,
+		DialTLSContext:  d.dialTLS,
+	}
+	d.httpClient = &http.Client{Transport: tr, Timeout: timeout}
+	return d
+}
+
+// dialTLS dials the underlying connection for the HTTP transport and
+// records its handshake duration, unless an earlier one has not yet been
+// consumed by takeHandshake.
+func (d *dohExchanger) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := (&tls.Dialer{Config: d.tlsConfig}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, &handshakeErr{err}
+	}
+	if !d.hasHandshake {
+		d.handshake = time.Since(start)
+		d.hasHandshake = true
+	}
+	return conn, nil
+}
+
+func (d *dohExchanger) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	hadHandshake := d.hasHandshake
+	start := time.Now()
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	dur := time.Since(start)
+	if !hadHandshake && d.hasHandshake {
+		// Do dialed a new connection; keep its handshake out of the RTT.
+		dur -= d.handshake
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, dur, fmt.Errorf("doh: unexpected status %s", resp.Status)
+	}
+
+	out := new(dns.Msg)
+	if err := out.Unpack(body); err != nil {
+		return nil, dur, err
+	}
+	return out, dur, nil
+}
+
+func (d *dohExchanger) takeHandshake() (time.Duration, bool) {
+	if !d.hasHandshake {
+		return 0, false
+	}
+	dur := d.handshake
+	d.hasHandshake = false
+	return dur, true
+}
+
+// doqExchanger implements DoQ (RFC 9250): one bidirectional QUIC stream per
+// query, the message length-prefixed with a uint16 as in DNS-over-TCP/DoT
+// framing.
+type doqExchanger struct {
+	addr         string
+	timeout      time.Duration
+	tlsConfig    *tls.Config
+	conn         *quic.Conn
+	handshake    time.Duration
+	hasHandshake bool
+}
+
+func newDoQExchanger(addr string, timeout time.Duration, insecure bool) *doqExchanger {
+	return &doqExchanger{
+		addr:    addr,
+		timeout: timeout,
+		tlsConfig: &tls.Config{
+			InsecureSkipVerify: insecure,
+			NextProtos:         []string{"doq"},
+		},
+	}
+}
+
+func (q *doqExchanger) dial(ctx context.Context) error {
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, q.addr, q.tlsConfig, nil)
+	if err != nil {
+		return &handshakeErr{err}
+	}
+	q.conn = conn
+	q.handshake = time.Since(start)
+	q.hasHandshake = true
+	return nil
+}
+
+func (q *doqExchanger) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	if q.conn == nil {
+		if err := q.dial(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// DoQ requires the query ID to be 0 on the wire.
+	id := m.Id
+	m.Id = 0
+	packed, err := m.Pack()
+	m.Id = id
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	stream, err := q.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	var lenPrefix [2]byte
+	binary.BigEndian.PutUint16(lenPrefix[:], uint16(len(packed)))
+	if _, err := stream.Write(append(lenPrefix[:], packed...)); err != nil {
+		return nil, 0, err
+	}
+	stream.Close()
+
+	respLenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(stream, respLenBuf); err != nil {
+		return nil, 0, err
+	}
+	respBuf := make([]byte, binary.BigEndian.Uint16(respLenBuf))
+	if _, err := io.ReadFull(stream, respBuf); err != nil {
+		return nil, 0, err
+	}
+	dur := time.Since(start)
+
+	out := new(dns.Msg)
+	if err := out.Unpack(respBuf); err != nil {
+		return nil, dur, err
+	}
+	out.Id = id
+	return out, dur, nil
+}
+
+func (q *doqExchanger) takeHandshake() (time.Duration, bool) {
+	if !q.hasHandshake {
+		return 0, false
+	}
+	d := q.handshake
+	q.hasHandshake = false
+	return d, true
+}